@@ -0,0 +1,96 @@
+package msgpack
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+)
+
+// ErrNonFiniteFloat is returned by CanonicalEncode when v contains a NaN or
+// infinite float and CanonicalOptions.AllowNonFinite is false.
+var ErrNonFiniteFloat = errors.New("msgpack: NaN and Inf floats are not canonically encodable")
+
+// CanonicalOptions customizes CanonicalEncode's behavior.
+type CanonicalOptions struct {
+	// AllowNonFinite permits encoding NaN and ±Inf floats, which
+	// CanonicalEncode rejects by default since they have no canonical,
+	// content-addressable representation (e.g. NaN has many distinct bit
+	// patterns).
+	AllowNonFinite bool
+}
+
+// CanonicalEncode encodes v the same way Marshal does, except it guarantees
+// byte-identical output for equal inputs: map keys are written in
+// lexicographic order, integers always use the smallest MessagePack format
+// that represents their value, and floats are narrowed from float64 to
+// float32 whenever that loses no precision. NaN and ±Inf floats are
+// rejected; use CanonicalEncodeWithOptions to allow them.
+//
+// This makes CanonicalEncode's output suitable for content-addressed
+// storage, signing, and reproducible diffs, none of which Marshal's output
+// is guaranteed to support.
+func CanonicalEncode(v any) ([]byte, error) {
+	return CanonicalEncodeWithOptions(v, CanonicalOptions{})
+}
+
+// CanonicalEncodeWithOptions is CanonicalEncode with explicit options.
+func CanonicalEncodeWithOptions(v any, opts CanonicalOptions) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	encOpts := encodeOptions{canonical: true, allowNonFinite: opts.AllowNonFinite}
+	if err := encodeReflect(buf, reflect.ValueOf(v), encOpts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeCanonicalInt writes n using the smallest MessagePack integer format
+// that represents it: positive fixint/uint8/16/32/64 for n >= 0, negative
+// fixint/int8/16/32/64 for n < 0. Its logic matches encodeSignedInt exactly;
+// it exists as its own function so CanonicalEncode's contract (smallest
+// size-minimal encoding) doesn't depend on encodeSignedInt's behavior
+// staying that way.
+func encodeCanonicalInt(buf *bytes.Buffer, n int64) {
+	if n >= 0 {
+		encodeUnsignedInt(buf, uint64(n))
+		return
+	}
+	switch {
+	case n >= -32:
+		buf.WriteByte(0xe0 | byte(n+32))
+	case n >= -128:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(n))
+	case n >= -32768:
+		buf.WriteByte(0xd1)
+		buf.Write([]byte{byte(n >> 8), byte(n)})
+	case n >= -(1 << 31):
+		buf.WriteByte(0xd2)
+		buf.Write([]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+	default:
+		buf.WriteByte(0xd3)
+		buf.Write([]byte{
+			byte(n >> 56), byte(n >> 48), byte(n >> 40), byte(n >> 32),
+			byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n),
+		})
+	}
+}
+
+// encodeCanonicalFloat writes f as a float32 when that round-trips exactly,
+// and as a float64 otherwise. NaN and ±Inf are rejected unless
+// opts.allowNonFinite is set.
+func encodeCanonicalFloat(buf *bytes.Buffer, f float64, opts encodeOptions) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		if !opts.allowNonFinite {
+			return ErrNonFiniteFloat
+		}
+		encodeFloat64(buf, f)
+		return nil
+	}
+	if f32 := float32(f); float64(f32) == f {
+		encodeFloat32(buf, f32)
+		return nil
+	}
+	encodeFloat64(buf, f)
+	return nil
+}
@@ -0,0 +1,68 @@
+package msgpack_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/HackHow/messagepack-project/pkg/msgpack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalEncodeIsDeterministic(t *testing.T) {
+	in := map[string]interface{}{
+		"z": 1,
+		"a": 2,
+		"m": 3,
+	}
+	first, err := msgpack.CanonicalEncode(in)
+	assert.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		again, err := msgpack.CanonicalEncode(in)
+		assert.NoError(t, err)
+		assert.Equal(t, first, again)
+	}
+}
+
+func TestCanonicalEncodePicksSmallestIntFormat(t *testing.T) {
+	cases := []struct {
+		name string
+		in   int64
+		want []byte
+	}{
+		{"positive fixint", 100, []byte{0x64}},
+		{"uint8, not int8", 200, []byte{0xcc, 0xc8}},
+		{"uint16", 1000, []byte{0xcd, 0x03, 0xe8}},
+		{"negative fixint", -1, []byte{0xff}},
+		{"int8", -100, []byte{0xd0, 0x9c}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := msgpack.CanonicalEncode(tc.in)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, out)
+		})
+	}
+}
+
+func TestCanonicalEncodeNarrowsFloatsThatRoundTrip(t *testing.T) {
+	out, err := msgpack.CanonicalEncode(float64(1.5))
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0xca), out[0]) // float32
+
+	out, err = msgpack.CanonicalEncode(math.Pi)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0xcb), out[0]) // float64, loses precision as float32
+}
+
+func TestCanonicalEncodeRejectsNonFiniteFloats(t *testing.T) {
+	_, err := msgpack.CanonicalEncode(math.NaN())
+	assert.Error(t, err)
+
+	_, err = msgpack.CanonicalEncode(math.Inf(1))
+	assert.Error(t, err)
+
+	out, err := msgpack.CanonicalEncodeWithOptions(math.Inf(1), msgpack.CanonicalOptions{AllowNonFinite: true})
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0xcb), out[0])
+}
@@ -5,28 +5,151 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math"
+	"strings"
+	"unicode/utf8"
 )
 
-// DecodeMsgPackToJSON decodes MessagePack binary data into JSON bytes.
+// byteReader is the minimal reading surface decodeValue and its helpers
+// need. Both *bytes.Reader (DecodeMsgPackToJSON) and *bufio.Reader
+// (Unmarshal and the streaming Decoder) satisfy it, so the core decoding
+// logic is shared between them.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// lenReader is implemented by *bytes.Reader, the byteReader DecodeMsgPackToJSON
+// decodes from. It lets safeReadN and the array/map header checks reject a
+// declared length that exceeds the remaining input immediately, instead of
+// first allocating it (e.g. a crafted array32 header can otherwise claim a
+// length of 0xffffffff and allocate ~4GB before the read fails). Streaming
+// sources such as *bufio.Reader don't implement it, since their total
+// length isn't known upfront; those rely on MaxDepth/MaxSize and bounded
+// preallocation instead.
+type lenReader interface {
+	Len() int
+}
+
+// Default limits used by DecodeMsgPackToJSON and DecodeMsgPackToJSONWithOptions
+// when the corresponding DecodeOptions field is zero.
+const (
+	DefaultMaxDepth = 512
+	DefaultMaxSize  = 1_000_000
+)
+
+// maxPreallocHint caps how large a slice/map readArray/readMap will
+// preallocate based on an attacker-controlled declared length; the
+// remainder is grown via append/assignment as elements actually decode.
+const maxPreallocHint = 4096
+
+// InvalidUTF8Policy controls how DecodeMsgPackToJSONWithOptions handles str
+// values that are not valid UTF-8.
+type InvalidUTF8Policy int
+
+const (
+	// InvalidUTF8Error rejects the decode with an error. This is the
+	// default used by DecodeMsgPackToJSON.
+	InvalidUTF8Error InvalidUTF8Policy = iota
+	// InvalidUTF8Replace replaces invalid byte sequences with the Unicode
+	// replacement character, matching strings.ToValidUTF8.
+	InvalidUTF8Replace
+)
+
+// DecodeOptions configures the adversarial-input defenses
+// DecodeMsgPackToJSONWithOptions applies: bounded nesting depth, a bound on
+// the total number of decoded values, and a policy for strings that aren't
+// valid UTF-8.
+type DecodeOptions struct {
+	// MaxDepth bounds array/map nesting depth. Zero means DefaultMaxDepth.
+	MaxDepth int
+	// MaxSize bounds the total number of values (scalars, array/map
+	// headers, string/bin payloads, ...) a single decode may produce.
+	// Zero means DefaultMaxSize.
+	MaxSize int
+	// InvalidUTF8 controls how non-UTF-8 strings are handled. The zero
+	// value, InvalidUTF8Error, rejects them.
+	InvalidUTF8 InvalidUTF8Policy
+}
+
+// decodeState tracks the limits DecodeOptions configures across a single
+// decodeValue call tree.
+type decodeState struct {
+	maxDepth    int
+	maxSize     int
+	invalidUTF8 InvalidUTF8Policy
+	depth       int
+	count       int
+}
+
+func newDecodeState(opts DecodeOptions) *decodeState {
+	maxDepth := opts.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	maxSize := opts.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	return &decodeState{maxDepth: maxDepth, maxSize: maxSize, invalidUTF8: opts.InvalidUTF8}
+}
+
+// countValue records one more decoded value, failing once MaxSize is
+// exceeded.
+func (s *decodeState) countValue() error {
+	s.count++
+	if s.count > s.maxSize {
+		return fmt.Errorf("msgpack: decoded value count exceeds MaxSize (%d)", s.maxSize)
+	}
+	return nil
+}
+
+// enterContainer records descending into an array or map, failing once
+// MaxDepth is exceeded. Every call must be paired with exitContainer.
+func (s *decodeState) enterContainer() error {
+	s.depth++
+	if s.depth > s.maxDepth {
+		return fmt.Errorf("msgpack: nesting depth exceeds MaxDepth (%d)", s.maxDepth)
+	}
+	return nil
+}
+
+func (s *decodeState) exitContainer() {
+	s.depth--
+}
+
+// DecodeMsgPackToJSON decodes MessagePack binary data into JSON bytes,
+// using DefaultMaxDepth, DefaultMaxSize and InvalidUTF8Error.
 func DecodeMsgPackToJSON(data []byte) ([]byte, error) {
-	buf := bytes.NewBuffer(data)
-	val, err := decodeValue(buf)
+	return DecodeMsgPackToJSONWithOptions(data, DecodeOptions{})
+}
+
+// DecodeMsgPackToJSONWithOptions is DecodeMsgPackToJSON with explicit
+// limits, for callers decoding untrusted input.
+func DecodeMsgPackToJSONWithOptions(data []byte, opts DecodeOptions) ([]byte, error) {
+	st := newDecodeState(opts)
+	val, err := decodeValue(bytes.NewReader(data), st)
 	if err != nil {
+		if err == io.EOF {
+			return nil, errors.New("empty buffer")
+		}
 		return nil, err
 	}
 	return json.Marshal(val)
 }
 
-// decodeValue decodes a single MessagePack value from the buffer.
-func decodeValue(buf *bytes.Buffer) (interface{}, error) {
-	if buf.Len() == 0 {
-		return nil, errors.New("empty buffer")
-	}
-	b, err := buf.ReadByte()
+// decodeValue decodes a single MessagePack value from r. If r has no data
+// at all, it returns io.EOF so that callers reading a stream of values (see
+// Decoder.Decode) can detect the end of the stream.
+func decodeValue(r byteReader, st *decodeState) (interface{}, error) {
+	b, err := r.ReadByte()
 	if err != nil {
 		return nil, err
 	}
+	if err := st.countValue(); err != nil {
+		return nil, err
+	}
 	// Handle fix types.
 	switch {
 	case b <= 0x7f:
@@ -35,13 +158,13 @@ func decodeValue(buf *bytes.Buffer) (interface{}, error) {
 		return int64(int8(b)), nil
 	case b >= 0xa0 && b <= 0xbf:
 		length := int(b & 0x1f)
-		return readString(buf, length)
+		return readString(r, length, st.invalidUTF8)
 	case b >= 0x90 && b <= 0x9f:
 		length := int(b & 0x0f)
-		return readArray(buf, length)
+		return readArray(r, length, st)
 	case b >= 0x80 && b <= 0x8f:
 		length := int(b & 0x0f)
-		return readMap(buf, length)
+		return readMap(r, length, st)
 	}
 	// Handle remaining codes.
 	switch b {
@@ -52,25 +175,25 @@ func decodeValue(buf *bytes.Buffer) (interface{}, error) {
 	case 0xc3:
 		return true, nil
 	case 0xcc:
-		v, err := buf.ReadByte()
+		v, err := r.ReadByte()
 		if err != nil {
 			return nil, err
 		}
 		return uint64(v), nil
 	case 0xcd:
-		bs, err := safeReadN(buf, 2)
+		bs, err := safeReadN(r, 2)
 		if err != nil {
 			return nil, err
 		}
 		return uint64(bs[0])<<8 | uint64(bs[1]), nil
 	case 0xce:
-		bs, err := safeReadN(buf, 4)
+		bs, err := safeReadN(r, 4)
 		if err != nil {
 			return nil, err
 		}
 		return uint64(bs[0])<<24 | uint64(bs[1])<<16 | uint64(bs[2])<<8 | uint64(bs[3]), nil
 	case 0xcf:
-		bs, err := safeReadN(buf, 8)
+		bs, err := safeReadN(r, 8)
 		if err != nil {
 			return nil, err
 		}
@@ -80,25 +203,25 @@ func decodeValue(buf *bytes.Buffer) (interface{}, error) {
 		}
 		return v, nil
 	case 0xd0:
-		v, err := buf.ReadByte()
+		v, err := r.ReadByte()
 		if err != nil {
 			return nil, err
 		}
 		return int8(v), nil
 	case 0xd1:
-		bs, err := safeReadN(buf, 2)
+		bs, err := safeReadN(r, 2)
 		if err != nil {
 			return nil, err
 		}
 		return int16(int(bs[0])<<8 | int(bs[1])), nil
 	case 0xd2:
-		bs, err := safeReadN(buf, 4)
+		bs, err := safeReadN(r, 4)
 		if err != nil {
 			return nil, err
 		}
 		return int32(int(bs[0])<<24 | int(bs[1])<<16 | int(bs[2])<<8 | int(bs[3])), nil
 	case 0xd3:
-		bs, err := safeReadN(buf, 8)
+		bs, err := safeReadN(r, 8)
 		if err != nil {
 			return nil, err
 		}
@@ -108,86 +231,227 @@ func decodeValue(buf *bytes.Buffer) (interface{}, error) {
 		}
 		return v, nil
 	case 0xca:
-		bs, err := safeReadN(buf, 4)
+		bs, err := safeReadN(r, 4)
 		if err != nil {
 			return nil, err
 		}
 		bits := uint32(bs[0])<<24 | uint32(bs[1])<<16 | uint32(bs[2])<<8 | uint32(bs[3])
 		return math.Float32frombits(bits), nil
 	case 0xcb:
-		bs, err := safeReadN(buf, 8)
+		bs, err := safeReadN(r, 8)
 		if err != nil {
 			return nil, err
 		}
 		return decodeFloat64(bs), nil
 	case 0xd9:
-		l, err := buf.ReadByte()
+		l, err := r.ReadByte()
 		if err != nil {
 			return nil, err
 		}
-		return readString(buf, int(l))
+		return readString(r, int(l), st.invalidUTF8)
 	case 0xda:
-		bs, err := safeReadN(buf, 2)
+		bs, err := safeReadN(r, 2)
 		if err != nil {
 			return nil, err
 		}
 		length := int(bs[0])<<8 | int(bs[1])
-		return readString(buf, length)
+		return readString(r, length, st.invalidUTF8)
 	case 0xdc:
-		bs, err := safeReadN(buf, 2)
+		bs, err := safeReadN(r, 2)
 		if err != nil {
 			return nil, err
 		}
 		length := int(bs[0])<<8 | int(bs[1])
-		return readArray(buf, length)
+		return readArray(r, length, st)
 	case 0xdd:
-		bs, err := safeReadN(buf, 4)
+		bs, err := safeReadN(r, 4)
 		if err != nil {
 			return nil, err
 		}
 		length := int(bs[0])<<24 | int(bs[1])<<16 | int(bs[2])<<8 | int(bs[3])
-		return readArray(buf, length)
+		return readArray(r, length, st)
 	case 0xde:
-		bs, err := safeReadN(buf, 2)
+		bs, err := safeReadN(r, 2)
 		if err != nil {
 			return nil, err
 		}
 		length := int(bs[0])<<8 | int(bs[1])
-		return readMap(buf, length)
+		return readMap(r, length, st)
 	case 0xdf:
-		bs, err := safeReadN(buf, 4)
+		bs, err := safeReadN(r, 4)
 		if err != nil {
 			return nil, err
 		}
 		length := int(bs[0])<<24 | int(bs[1])<<16 | int(bs[2])<<8 | int(bs[3])
-		return readMap(buf, length)
+		return readMap(r, length, st)
+	case 0xc4, 0xc5, 0xc6:
+		length, err := readBinHeaderLength(r, b)
+		if err != nil {
+			return nil, err
+		}
+		return safeReadN(r, length)
+	case 0xc7, 0xc8, 0xc9, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		typeCode, data, err := readExtHeader(r, b)
+		if err != nil {
+			return nil, err
+		}
+		return resolveExtension(typeCode, data)
 	default:
 		return nil, fmt.Errorf("unsupported byte: 0x%x", b)
 	}
 }
 
-// safeReadN reads n bytes from the buffer.
-func safeReadN(buf *bytes.Buffer, n int) ([]byte, error) {
-	if buf.Len() < n {
-		return nil, fmt.Errorf("unexpected EOF: need %d bytes, got %d", n, buf.Len())
+// readBinHeaderLength reads the length field following a bin 8/16/32
+// header byte.
+func readBinHeaderLength(r byteReader, b byte) (int, error) {
+	switch b {
+	case 0xc4:
+		l, err := r.ReadByte()
+		return int(l), err
+	case 0xc5:
+		bs, err := safeReadN(r, 2)
+		if err != nil {
+			return 0, err
+		}
+		return int(bs[0])<<8 | int(bs[1]), nil
+	default: // 0xc6
+		bs, err := safeReadN(r, 4)
+		if err != nil {
+			return 0, err
+		}
+		return int(bs[0])<<24 | int(bs[1])<<16 | int(bs[2])<<8 | int(bs[3]), nil
 	}
-	return buf.Next(n), nil
 }
 
-// readString reads a string of the given length.
-func readString(buf *bytes.Buffer, length int) (string, error) {
-	bs, err := safeReadN(buf, length)
+// readExtHeader reads the length (for ext 8/16/32; fixed for fixext), type
+// code and payload of an ext value, given its already-consumed header byte.
+func readExtHeader(r byteReader, b byte) (int8, []byte, error) {
+	var length int
+	switch b {
+	case 0xd4:
+		length = 1
+	case 0xd5:
+		length = 2
+	case 0xd6:
+		length = 4
+	case 0xd7:
+		length = 8
+	case 0xd8:
+		length = 16
+	case 0xc7:
+		l, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length = int(l)
+	case 0xc8:
+		bs, err := safeReadN(r, 2)
+		if err != nil {
+			return 0, nil, err
+		}
+		length = int(bs[0])<<8 | int(bs[1])
+	default: // 0xc9
+		bs, err := safeReadN(r, 4)
+		if err != nil {
+			return 0, nil, err
+		}
+		length = int(bs[0])<<24 | int(bs[1])<<16 | int(bs[2])<<8 | int(bs[3])
+	}
+
+	typeByte, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err := safeReadN(r, length)
+	if err != nil {
+		return 0, nil, err
+	}
+	return int8(typeByte), data, nil
+}
+
+// checkDeclaredLength rejects a negative length, or one that exceeds r's
+// remaining bytes when r exposes that (see lenReader).
+func checkDeclaredLength(r byteReader, length int) error {
+	if length < 0 {
+		return fmt.Errorf("msgpack: invalid length: %d", length)
+	}
+	if lr, ok := r.(lenReader); ok && length > lr.Len() {
+		return fmt.Errorf("msgpack: declared length %d exceeds %d remaining bytes", length, lr.Len())
+	}
+	return nil
+}
+
+// boundedCap clamps a declared length to a sane preallocation size; actual
+// growth beyond it happens through ordinary append/assignment.
+func boundedCap(length int) int {
+	if length > maxPreallocHint {
+		return maxPreallocHint
+	}
+	return length
+}
+
+// safeReadN reads exactly n bytes from r, the way every multi-byte
+// MessagePack header/payload is read: never more than the token declares,
+// and never an allocation larger than r could possibly still contain. When
+// r reports its remaining length (see lenReader) an oversized n is rejected
+// up front; otherwise an n above maxPreallocHint is read incrementally, so
+// the allocation grows only with the bytes r actually yields.
+func safeReadN(r byteReader, n int) ([]byte, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	if err := checkDeclaredLength(r, n); err != nil {
+		return nil, err
+	}
+	if _, ok := r.(lenReader); !ok && n > maxPreallocHint {
+		var grown bytes.Buffer
+		if _, err := io.CopyN(&grown, r, int64(n)); err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("unexpected EOF: need %d bytes", n)
+			}
+			return nil, err
+		}
+		return grown.Bytes(), nil
+	}
+	bs := make([]byte, n)
+	if _, err := io.ReadFull(r, bs); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("unexpected EOF: need %d bytes", n)
+		}
+		return nil, err
+	}
+	return bs, nil
+}
+
+// readString reads a string of the given length, applying policy to
+// payloads that aren't valid UTF-8.
+func readString(r byteReader, length int, policy InvalidUTF8Policy) (string, error) {
+	bs, err := safeReadN(r, length)
 	if err != nil {
 		return "", err
 	}
-	return string(bs), nil
+	if utf8.Valid(bs) {
+		return string(bs), nil
+	}
+	if policy == InvalidUTF8Replace {
+		return strings.ToValidUTF8(string(bs), string(utf8.RuneError)), nil
+	}
+	return "", fmt.Errorf("msgpack: invalid UTF-8 in string")
 }
 
-// readArray decodes an array from the buffer.
-func readArray(buf *bytes.Buffer, length int) ([]interface{}, error) {
-	arr := make([]interface{}, 0, length)
+// readArray decodes an array from r.
+func readArray(r byteReader, length int, st *decodeState) ([]interface{}, error) {
+	if err := checkDeclaredLength(r, length); err != nil {
+		return nil, err
+	}
+	if err := st.enterContainer(); err != nil {
+		return nil, err
+	}
+	defer st.exitContainer()
+
+	arr := make([]interface{}, 0, boundedCap(length))
 	for i := 0; i < length; i++ {
-		val, err := decodeValue(buf)
+		val, err := decodeValue(r, st)
 		if err != nil {
 			return nil, err
 		}
@@ -196,11 +460,19 @@ func readArray(buf *bytes.Buffer, length int) ([]interface{}, error) {
 	return arr, nil
 }
 
-// readMap decodes a map (with string keys) from the buffer.
-func readMap(buf *bytes.Buffer, length int) (map[string]interface{}, error) {
-	m := make(map[string]interface{}, length)
+// readMap decodes a map (with string keys) from r.
+func readMap(r byteReader, length int, st *decodeState) (map[string]interface{}, error) {
+	if err := checkDeclaredLength(r, length); err != nil {
+		return nil, err
+	}
+	if err := st.enterContainer(); err != nil {
+		return nil, err
+	}
+	defer st.exitContainer()
+
+	m := make(map[string]interface{}, boundedCap(length))
 	for i := 0; i < length; i++ {
-		keyRaw, err := decodeValue(buf)
+		keyRaw, err := decodeValue(r, st)
 		if err != nil {
 			return nil, err
 		}
@@ -208,7 +480,7 @@ func readMap(buf *bytes.Buffer, length int) (map[string]interface{}, error) {
 		if !ok {
 			return nil, errors.New("non-string map key")
 		}
-		val, err := decodeValue(buf)
+		val, err := decodeValue(r, st)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,47 @@
+package msgpack_test
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/HackHow/messagepack-project/pkg/msgpack"
+)
+
+// FuzzDecodeMsgPackToJSON exercises DecodeMsgPackToJSON against arbitrary
+// byte strings. It never asserts anything beyond "does not panic and does
+// not hang", since most fuzz-generated inputs are simply malformed; the
+// hardening in decode.go (MaxDepth, MaxSize, and the bin/array/map length
+// checks in safeReadN/readArray/readMap) is what's under test here, not any
+// particular decoded value.
+func FuzzDecodeMsgPackToJSON(f *testing.F) {
+	seed := func(hexStr string) {
+		data, err := hex.DecodeString(strings.ReplaceAll(hexStr, " ", ""))
+		if err != nil {
+			f.Fatalf("invalid seed hex: %v", err)
+		}
+		f.Add(data)
+	}
+
+	// A valid, complete value (the same encoding TestDecodeFromHexString
+	// uses for /testdata/cam01_basic.json).
+	basic := "85a86465766963654964a84331323334353637a56d6f64656cad" +
+		"4158495" + "32d5133353135" + "2d4c56a3667073" + "1ea97265736f6c7574696f6ea93" +
+		"13932307831303830a7656e61626c6564c3"
+	seed(basic)
+
+	// The same value, truncated mid-field.
+	seed(basic[:len(basic)-10])
+
+	seed("")                               // empty buffer
+	seed("c6ffffffff")                     // bin32 claiming ~4GB with no payload
+	seed("ddffffffff")                     // array32 claiming ~4GB with no payload
+	seed("dfffffffff")                     // map32 claiming ~4GB with no payload
+	seed("c9ffffffff2a")                   // ext32 claiming ~4GB with no payload
+	seed("a2fffe")                         // fixstr containing invalid UTF-8
+	seed(strings.Repeat("91", 600) + "00") // nested fixarrays past DefaultMaxDepth
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = msgpack.DecodeMsgPackToJSON(data)
+	})
+}
@@ -0,0 +1,77 @@
+package msgpack_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/HackHow/messagepack-project/pkg/msgpack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeRejectsArrayLengthExceedingRemainingBytes(t *testing.T) {
+	// array32 header claiming 0xffffffff elements, followed by nothing.
+	data := []byte{0xdd, 0xff, 0xff, 0xff, 0xff}
+	_, err := msgpack.DecodeMsgPackToJSON(data)
+	assert.Error(t, err)
+}
+
+func TestDecodeRejectsBinLengthExceedingRemainingBytes(t *testing.T) {
+	// bin32 header claiming 0xffffffff bytes, followed by nothing.
+	data := []byte{0xc6, 0xff, 0xff, 0xff, 0xff}
+	_, err := msgpack.DecodeMsgPackToJSON(data)
+	assert.Error(t, err)
+}
+
+func TestUnmarshalRejectsArrayLengthExceedingRemainingBytes(t *testing.T) {
+	// array32 header claiming 0xffffffff elements, followed by nothing: must
+	// error out instead of preallocating a slice of that length.
+	data := []byte{0xdd, 0xff, 0xff, 0xff, 0xff}
+	var out []int64
+	err := msgpack.Unmarshal(data, &out)
+	assert.Error(t, err)
+}
+
+func TestUnmarshalRejectsMapLengthExceedingRemainingBytes(t *testing.T) {
+	// map32 header claiming 0xffffffff entries, followed by nothing: must
+	// error out instead of preallocating a map of that size.
+	data := []byte{0xdf, 0xff, 0xff, 0xff, 0xff}
+	var out map[string]int
+	err := msgpack.Unmarshal(data, &out)
+	assert.Error(t, err)
+}
+
+func TestDecodeEnforcesMaxDepth(t *testing.T) {
+	var data []byte
+	for i := 0; i < 10; i++ {
+		data = append(data, 0x91) // fixarray of length 1
+	}
+	data = append(data, 0x00) // innermost element: fixint 0
+
+	_, err := msgpack.DecodeMsgPackToJSONWithOptions(data, msgpack.DecodeOptions{MaxDepth: 5})
+	assert.Error(t, err)
+
+	_, err = msgpack.DecodeMsgPackToJSONWithOptions(data, msgpack.DecodeOptions{MaxDepth: 20})
+	assert.NoError(t, err)
+}
+
+func TestDecodeEnforcesMaxSize(t *testing.T) {
+	data := []byte{0x93, 0x01, 0x02, 0x03} // fixarray of 3 fixints
+
+	_, err := msgpack.DecodeMsgPackToJSONWithOptions(data, msgpack.DecodeOptions{MaxSize: 2})
+	assert.Error(t, err)
+
+	_, err = msgpack.DecodeMsgPackToJSONWithOptions(data, msgpack.DecodeOptions{MaxSize: 10})
+	assert.NoError(t, err)
+}
+
+func TestDecodeInvalidUTF8Policy(t *testing.T) {
+	// fixstr of length 2 containing an invalid UTF-8 byte sequence.
+	data := []byte{0xa2, 0xff, 0xfe}
+
+	_, err := msgpack.DecodeMsgPackToJSONWithOptions(data, msgpack.DecodeOptions{})
+	assert.Error(t, err)
+
+	out, err := msgpack.DecodeMsgPackToJSONWithOptions(data, msgpack.DecodeOptions{InvalidUTF8: msgpack.InvalidUTF8Replace})
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(out), "�"))
+}
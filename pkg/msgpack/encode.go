@@ -7,6 +7,7 @@ import (
 	"math"
 	"reflect"
 	"sort"
+	"time"
 )
 
 // EncodeJSONToMsgPack converts JSON bytes to MessagePack format.
@@ -38,50 +39,22 @@ func encodeValue(buf *bytes.Buffer, v interface{}) error {
 		if val == float64(int64(val)) {
 			return encodeSignedInt(buf, int64(val))
 		} else {
-			buf.WriteByte(0xcb)
-			bits := math.Float64bits(val)
-			for i := 7; i >= 0; i-- {
-				buf.WriteByte(byte(bits >> (i * 8)))
-			}
+			encodeFloat64(buf, val)
 		}
 	case float32:
-		buf.WriteByte(0xca)
-		bits := math.Float32bits(val)
-		for i := 3; i >= 0; i-- {
-			buf.WriteByte(byte(bits >> (i * 8)))
-		}
+		encodeFloat32(buf, val)
 	case string:
-		strLen := len(val)
-		if strLen <= 31 {
-			buf.WriteByte(0xa0 | byte(strLen))
-		} else if strLen <= 255 {
-			buf.WriteByte(0xd9)
-			buf.WriteByte(byte(strLen))
-		} else {
-			buf.WriteByte(0xda)
-			buf.Write([]byte{byte(strLen >> 8), byte(strLen)})
-		}
-		buf.WriteString(val)
+		encodeString(buf, val)
 	case int, int8, int16, int32, int64:
 		return encodeSignedInt(buf, reflect.ValueOf(val).Int())
 	case uint, uint8, uint16, uint32, uint64:
 		return encodeUnsignedInt(buf, reflect.ValueOf(val).Uint())
+	case []byte:
+		encodeBin(buf, val)
+	case time.Time:
+		encodeTimestamp(buf, val)
 	case []interface{}:
-		length := len(val)
-		if length <= 15 {
-			buf.WriteByte(0x90 | byte(length))
-		} else if length <= 65535 {
-			buf.WriteByte(0xdc)
-			buf.Write([]byte{byte(length >> 8), byte(length)})
-		} else {
-			buf.WriteByte(0xdd)
-			buf.Write([]byte{
-				byte(length >> 24),
-				byte(length >> 16),
-				byte(length >> 8),
-				byte(length),
-			})
-		}
+		writeArrayHeader(buf, len(val))
 		for _, elem := range val {
 			if err := encodeValue(buf, elem); err != nil {
 				return err
@@ -94,25 +67,9 @@ func encodeValue(buf *bytes.Buffer, v interface{}) error {
 			keys = append(keys, k)
 		}
 		sort.Strings(keys)
-		length := len(val)
-		if length <= 15 {
-			buf.WriteByte(0x80 | byte(length))
-		} else if length <= 65535 {
-			buf.WriteByte(0xde)
-			buf.Write([]byte{byte(length >> 8), byte(length)})
-		} else {
-			buf.WriteByte(0xdf)
-			buf.Write([]byte{
-				byte(length >> 24),
-				byte(length >> 16),
-				byte(length >> 8),
-				byte(length),
-			})
-		}
+		writeMapHeader(buf, len(val))
 		for _, k := range keys {
-			if err := encodeValue(buf, k); err != nil {
-				return err
-			}
+			encodeString(buf, k)
 			if err := encodeValue(buf, val[k]); err != nil {
 				return err
 			}
@@ -125,76 +82,123 @@ func encodeValue(buf *bytes.Buffer, v interface{}) error {
 		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 			return encodeUnsignedInt(buf, rv.Uint())
 		default:
+			if ok, err := tryEncodeExtension(buf, v); ok {
+				return err
+			}
 			return fmt.Errorf("unsupported type: %T", v)
 		}
 	}
 	return nil
 }
 
-// encodeSignedInt encodes a signed integer.
+// encodeString encodes a string using the shortest applicable str header.
+func encodeString(buf *bytes.Buffer, val string) {
+	strLen := len(val)
+	if strLen <= 31 {
+		buf.WriteByte(0xa0 | byte(strLen))
+	} else if strLen <= 255 {
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(strLen))
+	} else if strLen <= 65535 {
+		buf.WriteByte(0xda)
+		buf.Write([]byte{byte(strLen >> 8), byte(strLen)})
+	} else {
+		buf.WriteByte(0xdb)
+		buf.Write([]byte{byte(strLen >> 24), byte(strLen >> 16), byte(strLen >> 8), byte(strLen)})
+	}
+	buf.WriteString(val)
+}
+
+// encodeFloat32 encodes a float32 using the float 32 format.
+func encodeFloat32(buf *bytes.Buffer, val float32) {
+	buf.WriteByte(0xca)
+	bits := math.Float32bits(val)
+	for i := 3; i >= 0; i-- {
+		buf.WriteByte(byte(bits >> (i * 8)))
+	}
+}
+
+// encodeFloat64 encodes a float64 using the float 64 format.
+func encodeFloat64(buf *bytes.Buffer, val float64) {
+	buf.WriteByte(0xcb)
+	bits := math.Float64bits(val)
+	for i := 7; i >= 0; i-- {
+		buf.WriteByte(byte(bits >> (i * 8)))
+	}
+}
+
+// writeArrayHeader writes the shortest array header for the given length.
+func writeArrayHeader(buf *bytes.Buffer, length int) {
+	if length <= 15 {
+		buf.WriteByte(0x90 | byte(length))
+	} else if length <= 65535 {
+		buf.WriteByte(0xdc)
+		buf.Write([]byte{byte(length >> 8), byte(length)})
+	} else {
+		buf.WriteByte(0xdd)
+		buf.Write([]byte{
+			byte(length >> 24),
+			byte(length >> 16),
+			byte(length >> 8),
+			byte(length),
+		})
+	}
+}
+
+// writeMapHeader writes the shortest map header for the given length.
+func writeMapHeader(buf *bytes.Buffer, length int) {
+	if length <= 15 {
+		buf.WriteByte(0x80 | byte(length))
+	} else if length <= 65535 {
+		buf.WriteByte(0xde)
+		buf.Write([]byte{byte(length >> 8), byte(length)})
+	} else {
+		buf.WriteByte(0xdf)
+		buf.Write([]byte{
+			byte(length >> 24),
+			byte(length >> 16),
+			byte(length >> 8),
+			byte(length),
+		})
+	}
+}
+
+// encodeSignedInt encodes a signed integer. Non-negative values have no
+// sign to preserve, so they're encoded the same way encodeUnsignedInt would:
+// 0xd0 (int8) tops out at 127, and using it for 128-255 would write a value
+// that decodes back as negative.
 func encodeSignedInt(buf *bytes.Buffer, n int64) error {
 	if n >= 0 {
-		if n <= 127 {
-			buf.WriteByte(byte(n))
-			return nil
-		}
-		if n <= 255 {
-			buf.WriteByte(0xd0)
-			buf.WriteByte(byte(n))
-		} else if n <= 32767 {
-			buf.WriteByte(0xd1)
-			buf.Write([]byte{byte(n >> 8), byte(n)})
-		} else if n <= 2147483647 {
-			buf.WriteByte(0xd2)
-			buf.Write([]byte{
-				byte(n >> 24),
-				byte(n >> 16),
-				byte(n >> 8),
-				byte(n),
-			})
-		} else {
-			buf.WriteByte(0xd3)
-			buf.Write([]byte{
-				byte(n >> 56),
-				byte(n >> 48),
-				byte(n >> 40),
-				byte(n >> 32),
-				byte(n >> 24),
-				byte(n >> 16),
-				byte(n >> 8),
-				byte(n),
-			})
-		}
+		return encodeUnsignedInt(buf, uint64(n))
+	}
+	if n >= -32 {
+		buf.WriteByte(0xe0 | byte(n+32))
+	} else if n >= -128 {
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(n))
+	} else if n >= -32768 {
+		buf.WriteByte(0xd1)
+		buf.Write([]byte{byte(n >> 8), byte(n)})
+	} else if n >= -2147483648 {
+		buf.WriteByte(0xd2)
+		buf.Write([]byte{
+			byte(n >> 24),
+			byte(n >> 16),
+			byte(n >> 8),
+			byte(n),
+		})
 	} else {
-		if n >= -32 {
-			buf.WriteByte(0xe0 | byte(n+32))
-		} else if n >= -128 {
-			buf.WriteByte(0xd0)
-			buf.WriteByte(byte(n))
-		} else if n >= -32768 {
-			buf.WriteByte(0xd1)
-			buf.Write([]byte{byte(n >> 8), byte(n)})
-		} else if n >= -2147483648 {
-			buf.WriteByte(0xd2)
-			buf.Write([]byte{
-				byte(n >> 24),
-				byte(n >> 16),
-				byte(n >> 8),
-				byte(n),
-			})
-		} else {
-			buf.WriteByte(0xd3)
-			buf.Write([]byte{
-				byte(n >> 56),
-				byte(n >> 48),
-				byte(n >> 40),
-				byte(n >> 32),
-				byte(n >> 24),
-				byte(n >> 16),
-				byte(n >> 8),
-				byte(n),
-			})
-		}
+		buf.WriteByte(0xd3)
+		buf.Write([]byte{
+			byte(n >> 56),
+			byte(n >> 48),
+			byte(n >> 40),
+			byte(n >> 32),
+			byte(n >> 24),
+			byte(n >> 16),
+			byte(n >> 8),
+			byte(n),
+		})
 	}
 	return nil
 }
@@ -0,0 +1,219 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ExtensionEncoder encodes a registered extension's Go value to the raw
+// payload bytes stored inside a MessagePack ext value. It is tried against
+// every struct (other than time.Time) and array value before the encoder
+// falls back to encoding it generically, and against values of kinds the
+// encoder has no other representation for, so it should return
+// ErrExtensionUnsupported for values it does not handle,
+// letting the encoder fall through to the next registered extension (or to
+// its standard "unsupported type" error).
+type ExtensionEncoder func(v any) ([]byte, error)
+
+// ExtensionDecoder decodes a registered extension's raw payload bytes back
+// into a Go value.
+type ExtensionDecoder func(data []byte) (any, error)
+
+// ErrExtensionUnsupported is returned by an ExtensionEncoder to signal that
+// it does not know how to encode the given value.
+var ErrExtensionUnsupported = errors.New("msgpack: extension does not support this value")
+
+// timestampExtensionType is the MessagePack-reserved ext type code for the
+// predefined timestamp extension.
+const timestampExtensionType int8 = -1
+
+type extensionEntry struct {
+	typeCode int8
+	encode   ExtensionEncoder
+	decode   ExtensionDecoder
+}
+
+var (
+	extensionsMu      sync.RWMutex
+	extensionsInOrder []extensionEntry
+	extensionsByType  = map[int8]extensionEntry{}
+)
+
+// RegisterExtension registers an application-defined MessagePack extension
+// type. encode is tried, in registration order, against any struct or array
+// value the encoder would otherwise encode generically, and against values
+// of kinds the encoder cannot otherwise represent; it should return
+// ErrExtensionUnsupported for values it doesn't handle. decode converts the
+// raw payload of an ext value carrying typeCode back into a Go value.
+//
+// typeCode -1 is reserved for the predefined timestamp extension (see
+// time.Time support in Marshal/Unmarshal) and cannot be registered.
+func RegisterExtension(typeCode int8, encode ExtensionEncoder, decode ExtensionDecoder) error {
+	if typeCode == timestampExtensionType {
+		return fmt.Errorf("msgpack: extension type %d is reserved for timestamps", typeCode)
+	}
+
+	entry := extensionEntry{typeCode: typeCode, encode: encode, decode: decode}
+
+	extensionsMu.Lock()
+	defer extensionsMu.Unlock()
+	extensionsByType[typeCode] = entry
+	for i, existing := range extensionsInOrder {
+		if existing.typeCode == typeCode {
+			extensionsInOrder[i] = entry
+			return nil
+		}
+	}
+	extensionsInOrder = append(extensionsInOrder, entry)
+	return nil
+}
+
+// tryEncodeExtension tries every registered extension encoder, in
+// registration order, writing the ext value for the first one that accepts
+// v. ok reports whether one did.
+func tryEncodeExtension(buf *bytes.Buffer, v any) (ok bool, err error) {
+	extensionsMu.RLock()
+	entries := extensionsInOrder
+	extensionsMu.RUnlock()
+
+	for _, entry := range entries {
+		data, err := entry.encode(v)
+		if errors.Is(err, ErrExtensionUnsupported) {
+			continue
+		}
+		if err != nil {
+			return true, err
+		}
+		writeExt(buf, entry.typeCode, data)
+		return true, nil
+	}
+	return false, nil
+}
+
+func lookupExtensionDecoder(typeCode int8) (ExtensionDecoder, bool) {
+	extensionsMu.RLock()
+	defer extensionsMu.RUnlock()
+	entry, ok := extensionsByType[typeCode]
+	if !ok {
+		return nil, false
+	}
+	return entry.decode, true
+}
+
+// Extension holds the raw type code and payload of a decoded ext value that
+// has no registered decoder (and isn't the predefined timestamp type).
+type Extension struct {
+	Type int8
+	Data []byte
+}
+
+// resolveExtension turns a decoded (typeCode, data) ext pair into a Go
+// value: a time.Time for the predefined timestamp type, the result of a
+// registered decoder, or a raw Extension as a last resort.
+func resolveExtension(typeCode int8, data []byte) (interface{}, error) {
+	if typeCode == timestampExtensionType {
+		return decodeTimestamp(data)
+	}
+	if dec, ok := lookupExtensionDecoder(typeCode); ok {
+		return dec(data)
+	}
+	return Extension{Type: typeCode, Data: append([]byte(nil), data...)}, nil
+}
+
+// encodeBin writes val using the shortest applicable bin header.
+func encodeBin(buf *bytes.Buffer, val []byte) {
+	length := len(val)
+	switch {
+	case length <= 0xff:
+		buf.WriteByte(0xc4)
+		buf.WriteByte(byte(length))
+	case length <= 0xffff:
+		buf.WriteByte(0xc5)
+		buf.Write([]byte{byte(length >> 8), byte(length)})
+	default:
+		buf.WriteByte(0xc6)
+		buf.Write([]byte{byte(length >> 24), byte(length >> 16), byte(length >> 8), byte(length)})
+	}
+	buf.Write(val)
+}
+
+// writeExt writes data as a fixext (when its length is 1, 2, 4, 8 or 16) or
+// as an ext 8/16/32 value otherwise.
+func writeExt(buf *bytes.Buffer, typeCode int8, data []byte) {
+	switch len(data) {
+	case 1:
+		buf.WriteByte(0xd4)
+	case 2:
+		buf.WriteByte(0xd5)
+	case 4:
+		buf.WriteByte(0xd6)
+	case 8:
+		buf.WriteByte(0xd7)
+	case 16:
+		buf.WriteByte(0xd8)
+	default:
+		length := len(data)
+		switch {
+		case length <= 0xff:
+			buf.WriteByte(0xc7)
+			buf.WriteByte(byte(length))
+		case length <= 0xffff:
+			buf.WriteByte(0xc8)
+			buf.Write([]byte{byte(length >> 8), byte(length)})
+		default:
+			buf.WriteByte(0xc9)
+			buf.Write([]byte{byte(length >> 24), byte(length >> 16), byte(length >> 8), byte(length)})
+		}
+	}
+	buf.WriteByte(byte(typeCode))
+	buf.Write(data)
+}
+
+// encodeTimestamp writes t using the smallest of the three predefined
+// timestamp encodings (32-bit, 64-bit or 96-bit) that represents it exactly.
+func encodeTimestamp(buf *bytes.Buffer, t time.Time) {
+	sec := t.Unix()
+	nsec := t.Nanosecond()
+
+	if sec >= 0 && sec <= 0xffffffff && nsec == 0 {
+		data := make([]byte, 4)
+		binary.BigEndian.PutUint32(data, uint32(sec))
+		writeExt(buf, timestampExtensionType, data)
+		return
+	}
+	if sec >= 0 && uint64(sec) < (1<<34) {
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, uint64(nsec)<<34|uint64(sec))
+		writeExt(buf, timestampExtensionType, data)
+		return
+	}
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint32(data[:4], uint32(nsec))
+	binary.BigEndian.PutUint64(data[4:], uint64(sec))
+	writeExt(buf, timestampExtensionType, data)
+}
+
+// decodeTimestamp parses the payload of a timestamp extension value, in any
+// of the three forms encodeTimestamp can produce.
+func decodeTimestamp(data []byte) (time.Time, error) {
+	switch len(data) {
+	case 4:
+		sec := binary.BigEndian.Uint32(data)
+		return time.Unix(int64(sec), 0).UTC(), nil
+	case 8:
+		v := binary.BigEndian.Uint64(data)
+		nsec := v >> 34
+		sec := v & 0x3ffffffff
+		return time.Unix(int64(sec), int64(nsec)).UTC(), nil
+	case 12:
+		nsec := binary.BigEndian.Uint32(data[:4])
+		sec := int64(binary.BigEndian.Uint64(data[4:]))
+		return time.Unix(sec, int64(nsec)).UTC(), nil
+	default:
+		return time.Time{}, fmt.Errorf("msgpack: invalid timestamp payload length: %d", len(data))
+	}
+}
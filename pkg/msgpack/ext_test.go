@@ -0,0 +1,152 @@
+package msgpack_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/HackHow/messagepack-project/pkg/msgpack"
+	"github.com/stretchr/testify/assert"
+)
+
+type blob struct {
+	Data []byte    `msgpack:"data"`
+	At   time.Time `msgpack:"at"`
+}
+
+func TestMarshalUnmarshalBinAndTimestamp(t *testing.T) {
+	in := blob{
+		Data: []byte("hello world"),
+		At:   time.Date(2024, 3, 5, 10, 0, 0, 123456789, time.UTC),
+	}
+
+	data, err := msgpack.Marshal(in)
+	assert.NoError(t, err)
+
+	var out blob
+	assert.NoError(t, msgpack.Unmarshal(data, &out))
+	assert.Equal(t, in.Data, out.Data)
+	assert.True(t, in.At.Equal(out.At))
+}
+
+func TestDecodeMsgPackToJSONRendersBinAndTimestamp(t *testing.T) {
+	in := blob{
+		Data: []byte("hi"),
+		At:   time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC),
+	}
+	data, err := msgpack.Marshal(in)
+	assert.NoError(t, err)
+
+	jsonBytes, err := msgpack.DecodeMsgPackToJSON(data)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"at":"2024-03-05T10:00:00Z","data":"aGk="}`, string(jsonBytes))
+}
+
+func TestTimestampRoundTripsAtVariousPrecisions(t *testing.T) {
+	cases := []time.Time{
+		time.Unix(0, 0).UTC(),                       // 32-bit: whole seconds
+		time.Unix(1000000, 123456789).UTC(),         // 64-bit: seconds + nanos
+		time.Unix(-1, 0).UTC(),                      // 96-bit: negative seconds
+		time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC), // 96-bit: beyond 34-bit seconds
+	}
+	for _, tc := range cases {
+		data, err := msgpack.Marshal(tc)
+		assert.NoError(t, err)
+
+		var out time.Time
+		assert.NoError(t, msgpack.Unmarshal(data, &out))
+		assert.True(t, tc.Equal(out))
+	}
+}
+
+type uuid [16]byte
+
+func TestRegisterExtensionRoundTrip(t *testing.T) {
+	err := msgpack.RegisterExtension(
+		77,
+		func(v any) ([]byte, error) {
+			u, ok := v.(uuid)
+			if !ok {
+				return nil, msgpack.ErrExtensionUnsupported
+			}
+			return u[:], nil
+		},
+		func(data []byte) (any, error) {
+			var u uuid
+			copy(u[:], data)
+			return u, nil
+		},
+	)
+	assert.NoError(t, err)
+
+	in := uuid{1, 2, 3, 4, 5}
+	data, err := msgpack.Marshal(in)
+	assert.NoError(t, err)
+
+	var out uuid
+	assert.NoError(t, msgpack.Unmarshal(data, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestRegisterExtensionRejectsTimestampTypeCode(t *testing.T) {
+	err := msgpack.RegisterExtension(-1, nil, nil)
+	assert.Error(t, err)
+}
+
+type widget struct{}
+
+// TestRegisterExtensionReplacesPriorEncoder guards against re-registering a
+// typeCode leaving a stale encoder ahead of the new one in
+// extensionsInOrder: tryEncodeExtension must use the most recently
+// registered encode/decode pair for that typeCode, not the first one ever
+// registered.
+func TestRegisterExtensionReplacesPriorEncoder(t *testing.T) {
+	const typeCode = 99
+	encode := func(marker string) msgpack.ExtensionEncoder {
+		return func(v any) ([]byte, error) {
+			if _, ok := v.(widget); !ok {
+				return nil, msgpack.ErrExtensionUnsupported
+			}
+			return []byte(marker), nil
+		}
+	}
+	decode := func(data []byte) (any, error) { return string(data), nil }
+
+	assert.NoError(t, msgpack.RegisterExtension(typeCode, encode("v1"), decode))
+	assert.NoError(t, msgpack.RegisterExtension(typeCode, encode("v2"), decode))
+
+	data, err := msgpack.Marshal(widget{})
+	assert.NoError(t, err)
+
+	var out string
+	assert.NoError(t, msgpack.Unmarshal(data, &out))
+	assert.Equal(t, "v2", out)
+}
+
+// TestEncodeJSONToMsgPackDoesNotGuessStringContent guards against
+// EncodeJSONToMsgPack reinterpreting an ordinary JSON string as bin or a
+// timestamp just because its content happens to parse as base64 or
+// RFC3339: a plain alphanumeric ID ("C1234567") and a word that happens to
+// be valid base64 ("code") must both encode as plain msgpack str, and a
+// string that happens to look like a timestamp must too.
+func TestEncodeJSONToMsgPackDoesNotGuessStringContent(t *testing.T) {
+	cases := []string{"C1234567", "code", "2024-03-05T10:00:00Z"}
+	for _, s := range cases {
+		jsonBytes := []byte(`{"value":"` + s + `"}`)
+		data, err := msgpack.EncodeJSONToMsgPack(jsonBytes)
+		assert.NoError(t, err)
+
+		it := msgpack.NewIterator(data)
+		top, err := it.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, msgpack.TokenMap, top.Type)
+
+		key, err := it.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, "value", key.String())
+
+		val, err := it.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, msgpack.TokenString, val.Type)
+		assert.Equal(t, s, val.String())
+	}
+}
@@ -0,0 +1,356 @@
+package msgpack
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// TokenType identifies the kind of value a Token holds.
+type TokenType int
+
+const (
+	TokenNil TokenType = iota
+	TokenBool
+	TokenInt
+	TokenUint
+	TokenFloat32
+	TokenFloat64
+	TokenString
+	TokenBin
+	TokenArray
+	TokenMap
+	TokenExt
+)
+
+// Token is a single MessagePack value header, as returned by
+// Iterator.Next. Exactly one of its scalar fields is meaningful, selected
+// by Type; Bytes and Length are populated as noted below.
+//
+// Bytes, for TokenString and TokenBin, is a sub-slice of the buffer passed
+// to NewIterator: reading it never allocates or copies. The Iterator never
+// reuses it, so it stays valid across later calls to Next, Skip or Raw, but
+// it aliases the source buffer and reflects any later change to it. Call
+// Token.String() (which does copy) for a value independent of that buffer.
+type Token struct {
+	Type    TokenType
+	Bool    bool
+	Int     int64
+	Uint    uint64
+	Float32 float32
+	Float64 float64
+	Bytes   []byte // TokenString, TokenBin, TokenExt: zero-copy view into the source buffer
+	Length  int    // TokenArray: element count. TokenMap: key/value pair count.
+	ExtType int8   // TokenExt
+}
+
+// String copies Bytes into a string. For TokenString this is the decoded
+// string value.
+func (t Token) String() string {
+	return string(t.Bytes)
+}
+
+// Iterator walks a MessagePack-encoded buffer one token at a time without
+// allocating: unlike DecodeMsgPackToJSON and Unmarshal, it never builds a
+// map[string]interface{} tree, and strings/bin are returned as sub-slices
+// of the source buffer rather than copies. It's suited to reading a
+// handful of fields out of a large message.
+//
+// Array and map values are returned as a single Token carrying their
+// declared element (or key/value pair) count; the caller is responsible
+// for calling Next that many times (twice, for maps) to consume their
+// contents, or calling Skip to discard them.
+type Iterator struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+// NewIterator returns an Iterator over data. data is not copied; the
+// Iterator's Token.Bytes views alias it directly.
+func NewIterator(data []byte) *Iterator {
+	return &Iterator{data: data}
+}
+
+// Next decodes and returns the next token. It returns io.EOF once the
+// buffer is exhausted.
+func (it *Iterator) Next() (Token, error) {
+	b, err := it.readByte()
+	if err != nil {
+		return Token{}, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return Token{Type: TokenInt, Int: int64(b)}, nil
+	case b >= 0xe0:
+		return Token{Type: TokenInt, Int: int64(int8(b))}, nil
+	case b >= 0xa0 && b <= 0xbf:
+		return it.stringToken(int(b & 0x1f))
+	case b >= 0x90 && b <= 0x9f:
+		return Token{Type: TokenArray, Length: int(b & 0x0f)}, nil
+	case b >= 0x80 && b <= 0x8f:
+		return Token{Type: TokenMap, Length: int(b & 0x0f)}, nil
+	}
+
+	switch b {
+	case 0xc0:
+		return Token{Type: TokenNil}, nil
+	case 0xc2:
+		return Token{Type: TokenBool, Bool: false}, nil
+	case 0xc3:
+		return Token{Type: TokenBool, Bool: true}, nil
+	case 0xcc:
+		v, err := it.readByte()
+		return Token{Type: TokenUint, Uint: uint64(v)}, err
+	case 0xcd:
+		bs, err := it.readN(2)
+		if err != nil {
+			return Token{}, err
+		}
+		return Token{Type: TokenUint, Uint: uint64(bs[0])<<8 | uint64(bs[1])}, nil
+	case 0xce:
+		bs, err := it.readN(4)
+		if err != nil {
+			return Token{}, err
+		}
+		return Token{Type: TokenUint, Uint: uint64(bs[0])<<24 | uint64(bs[1])<<16 | uint64(bs[2])<<8 | uint64(bs[3])}, nil
+	case 0xcf:
+		bs, err := it.readN(8)
+		if err != nil {
+			return Token{}, err
+		}
+		return Token{Type: TokenUint, Uint: beUint64(bs)}, nil
+	case 0xd0:
+		v, err := it.readByte()
+		return Token{Type: TokenInt, Int: int64(int8(v))}, err
+	case 0xd1:
+		bs, err := it.readN(2)
+		if err != nil {
+			return Token{}, err
+		}
+		return Token{Type: TokenInt, Int: int64(int16(int(bs[0])<<8 | int(bs[1])))}, nil
+	case 0xd2:
+		bs, err := it.readN(4)
+		if err != nil {
+			return Token{}, err
+		}
+		return Token{Type: TokenInt, Int: int64(int32(int(bs[0])<<24 | int(bs[1])<<16 | int(bs[2])<<8 | int(bs[3])))}, nil
+	case 0xd3:
+		bs, err := it.readN(8)
+		if err != nil {
+			return Token{}, err
+		}
+		return Token{Type: TokenInt, Int: int64(beUint64(bs))}, nil
+	case 0xca:
+		bs, err := it.readN(4)
+		if err != nil {
+			return Token{}, err
+		}
+		bits := uint32(bs[0])<<24 | uint32(bs[1])<<16 | uint32(bs[2])<<8 | uint32(bs[3])
+		return Token{Type: TokenFloat32, Float32: math.Float32frombits(bits)}, nil
+	case 0xcb:
+		bs, err := it.readN(8)
+		if err != nil {
+			return Token{}, err
+		}
+		return Token{Type: TokenFloat64, Float64: math.Float64frombits(beUint64(bs))}, nil
+	case 0xd9:
+		l, err := it.readByte()
+		if err != nil {
+			return Token{}, err
+		}
+		return it.stringToken(int(l))
+	case 0xda:
+		bs, err := it.readN(2)
+		if err != nil {
+			return Token{}, err
+		}
+		return it.stringToken(int(bs[0])<<8 | int(bs[1]))
+	case 0xdc:
+		bs, err := it.readN(2)
+		if err != nil {
+			return Token{}, err
+		}
+		return Token{Type: TokenArray, Length: int(bs[0])<<8 | int(bs[1])}, nil
+	case 0xdd:
+		bs, err := it.readN(4)
+		if err != nil {
+			return Token{}, err
+		}
+		return Token{Type: TokenArray, Length: int(bs[0])<<24 | int(bs[1])<<16 | int(bs[2])<<8 | int(bs[3])}, nil
+	case 0xde:
+		bs, err := it.readN(2)
+		if err != nil {
+			return Token{}, err
+		}
+		return Token{Type: TokenMap, Length: int(bs[0])<<8 | int(bs[1])}, nil
+	case 0xdf:
+		bs, err := it.readN(4)
+		if err != nil {
+			return Token{}, err
+		}
+		return Token{Type: TokenMap, Length: int(bs[0])<<24 | int(bs[1])<<16 | int(bs[2])<<8 | int(bs[3])}, nil
+	case 0xc4:
+		l, err := it.readByte()
+		if err != nil {
+			return Token{}, err
+		}
+		return it.binToken(int(l))
+	case 0xc5:
+		bs, err := it.readN(2)
+		if err != nil {
+			return Token{}, err
+		}
+		return it.binToken(int(bs[0])<<8 | int(bs[1]))
+	case 0xc6:
+		bs, err := it.readN(4)
+		if err != nil {
+			return Token{}, err
+		}
+		return it.binToken(int(bs[0])<<24 | int(bs[1])<<16 | int(bs[2])<<8 | int(bs[3]))
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xc7, 0xc8, 0xc9:
+		return it.extToken(b)
+	default:
+		return Token{}, fmt.Errorf("msgpack: unsupported byte: 0x%x", b)
+	}
+}
+
+// Skip advances past the next complete value, including every element of
+// an array or every key/value pair of a map, without allocating. It's
+// equivalent to calling Next and, for TokenArray/TokenMap, recursively
+// skipping its Length (or 2*Length) nested values.
+//
+// Skip bounds its recursion to DefaultMaxDepth, the same limit decodeValue
+// enforces, so a buffer of deeply nested single-element containers fails
+// with an error instead of overflowing the goroutine stack.
+func (it *Iterator) Skip() error {
+	tok, err := it.Next()
+	if err != nil {
+		return err
+	}
+	n := 0
+	switch tok.Type {
+	case TokenArray:
+		n = tok.Length
+	case TokenMap:
+		n = 2 * tok.Length
+	default:
+		return nil
+	}
+
+	it.depth++
+	defer func() { it.depth-- }()
+	if it.depth > DefaultMaxDepth {
+		return fmt.Errorf("msgpack: nesting depth exceeds MaxDepth (%d)", DefaultMaxDepth)
+	}
+	for i := 0; i < n; i++ {
+		if err := it.Skip(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Raw returns the exact encoded bytes of the next complete value — a
+// sub-slice of the buffer passed to NewIterator — without decoding it,
+// for callers that want to lazily Unmarshal only the values they need.
+func (it *Iterator) Raw() ([]byte, error) {
+	start := it.pos
+	if err := it.Skip(); err != nil {
+		return nil, err
+	}
+	return it.data[start:it.pos], nil
+}
+
+func (it *Iterator) stringToken(length int) (Token, error) {
+	bs, err := it.readN(length)
+	if err != nil {
+		return Token{}, err
+	}
+	return Token{Type: TokenString, Bytes: bs}, nil
+}
+
+func (it *Iterator) binToken(length int) (Token, error) {
+	bs, err := it.readN(length)
+	if err != nil {
+		return Token{}, err
+	}
+	return Token{Type: TokenBin, Bytes: bs}, nil
+}
+
+// extToken reads the length (for ext 8/16/32; fixed for fixext), type code
+// and payload of an ext value, given its already-consumed header byte.
+func (it *Iterator) extToken(b byte) (Token, error) {
+	var length int
+	switch b {
+	case 0xd4:
+		length = 1
+	case 0xd5:
+		length = 2
+	case 0xd6:
+		length = 4
+	case 0xd7:
+		length = 8
+	case 0xd8:
+		length = 16
+	case 0xc7:
+		l, err := it.readByte()
+		if err != nil {
+			return Token{}, err
+		}
+		length = int(l)
+	case 0xc8:
+		bs, err := it.readN(2)
+		if err != nil {
+			return Token{}, err
+		}
+		length = int(bs[0])<<8 | int(bs[1])
+	default: // 0xc9
+		bs, err := it.readN(4)
+		if err != nil {
+			return Token{}, err
+		}
+		length = int(bs[0])<<24 | int(bs[1])<<16 | int(bs[2])<<8 | int(bs[3])
+	}
+
+	typeByte, err := it.readByte()
+	if err != nil {
+		return Token{}, err
+	}
+	data, err := it.readN(length)
+	if err != nil {
+		return Token{}, err
+	}
+	return Token{Type: TokenExt, ExtType: int8(typeByte), Bytes: data}, nil
+}
+
+func (it *Iterator) readByte() (byte, error) {
+	if it.pos >= len(it.data) {
+		return 0, io.EOF
+	}
+	b := it.data[it.pos]
+	it.pos++
+	return b, nil
+}
+
+// readN returns the next n bytes as a sub-slice of it.data — never a copy
+// — advancing past them. It fails if n would reach past the end of data,
+// so a crafted header can't claim a length longer than what's actually
+// left to read.
+func (it *Iterator) readN(n int) ([]byte, error) {
+	if n < 0 || n > len(it.data)-it.pos {
+		return nil, fmt.Errorf("msgpack: declared length %d exceeds %d remaining bytes", n, len(it.data)-it.pos)
+	}
+	bs := it.data[it.pos : it.pos+n]
+	it.pos += n
+	return bs, nil
+}
+
+func beUint64(bs []byte) uint64 {
+	var v uint64
+	for i := 0; i < 8; i++ {
+		v = (v << 8) | uint64(bs[i])
+	}
+	return v
+}
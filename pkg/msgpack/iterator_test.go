@@ -0,0 +1,151 @@
+package msgpack_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/HackHow/messagepack-project/pkg/msgpack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIteratorWalksMapKeysAndValues(t *testing.T) {
+	data, err := msgpack.Marshal(map[string]interface{}{
+		"name": "Ada",
+		"age":  36,
+	})
+	assert.NoError(t, err)
+
+	it := msgpack.NewIterator(data)
+
+	tok, err := it.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, msgpack.TokenMap, tok.Type)
+	assert.Equal(t, 2, tok.Length)
+
+	got := map[string]int64{}
+	for i := 0; i < tok.Length; i++ {
+		key, err := it.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, msgpack.TokenString, key.Type)
+
+		val, err := it.Next()
+		assert.NoError(t, err)
+		if val.Type == msgpack.TokenInt {
+			got[key.String()] = val.Int
+		} else {
+			got[key.String()] = 0
+		}
+	}
+	assert.Equal(t, int64(36), got["age"])
+}
+
+func TestIteratorStringAndBinAreZeroCopyViews(t *testing.T) {
+	data, err := msgpack.Marshal([]interface{}{"hello", []byte("world")})
+	assert.NoError(t, err)
+
+	it := msgpack.NewIterator(data)
+	arr, err := it.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, msgpack.TokenArray, arr.Type)
+
+	str, err := it.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, msgpack.TokenString, str.Type)
+	assert.Equal(t, "hello", str.String())
+	strStart := indexOf(data, str.Bytes)
+	assert.True(t, strStart >= 0)
+
+	bin, err := it.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, msgpack.TokenBin, bin.Type)
+	assert.Equal(t, []byte("world"), bin.Bytes)
+	binStart := indexOf(data, bin.Bytes)
+	assert.True(t, binStart >= 0)
+}
+
+func TestIteratorSkipJumpsOverNestedContainers(t *testing.T) {
+	data, err := msgpack.Marshal(map[string]interface{}{
+		"skip": []interface{}{1, 2, []interface{}{3, 4}},
+		"keep": "value",
+	})
+	assert.NoError(t, err)
+
+	it := msgpack.NewIterator(data)
+	top, err := it.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, msgpack.TokenMap, top.Type)
+
+	var keepValue string
+	for i := 0; i < top.Length; i++ {
+		key, err := it.Next()
+		assert.NoError(t, err)
+		if key.String() == "keep" {
+			val, err := it.Next()
+			assert.NoError(t, err)
+			keepValue = val.String()
+		} else {
+			assert.NoError(t, it.Skip())
+		}
+	}
+	assert.Equal(t, "value", keepValue)
+
+	_, err = it.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestIteratorRawReturnsEncodedBytesForLazyDecode(t *testing.T) {
+	type address struct {
+		City string `msgpack:"city"`
+	}
+	data, err := msgpack.Marshal(map[string]interface{}{
+		"address": address{City: "Berlin"},
+	})
+	assert.NoError(t, err)
+
+	it := msgpack.NewIterator(data)
+	top, err := it.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, msgpack.TokenMap, top.Type)
+
+	key, err := it.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "address", key.String())
+
+	raw, err := it.Raw()
+	assert.NoError(t, err)
+
+	var decoded address
+	assert.NoError(t, msgpack.Unmarshal(raw, &decoded))
+	assert.Equal(t, "Berlin", decoded.City)
+}
+
+func TestIteratorNextReturnsEOFAtEnd(t *testing.T) {
+	it := msgpack.NewIterator(nil)
+	_, err := it.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestIteratorSkipEnforcesMaxDepth(t *testing.T) {
+	// Deeply nested single-element fixarrays, past DefaultMaxDepth: Skip
+	// must error out instead of recursing until the stack overflows.
+	var data []byte
+	for i := 0; i < 1000; i++ {
+		data = append(data, 0x91) // fixarray of length 1
+	}
+	data = append(data, 0x00) // innermost element: fixint 0
+
+	it := msgpack.NewIterator(data)
+	assert.Error(t, it.Skip())
+}
+
+func indexOf(haystack, needle []byte) int {
+	if len(needle) == 0 {
+		return -1
+	}
+	for i := 0; i+len(needle) <= len(haystack); i++ {
+		if &haystack[i] == &needle[0] {
+			return i
+		}
+	}
+	return -1
+}
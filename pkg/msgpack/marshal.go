@@ -0,0 +1,203 @@
+package msgpack
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Marshaler is implemented by types that can encode themselves directly to
+// MessagePack bytes, analogous to encoding/json's Marshaler. The returned
+// bytes must be one complete, self-contained MessagePack value.
+type Marshaler interface {
+	MarshalMsgPack() ([]byte, error)
+}
+
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
+// Marshal encodes v to MessagePack using Go struct tags, operating directly
+// on v's type via reflection instead of bridging through encoding/json.
+// Field names are taken from `msgpack` tags, falling back to `json` tags,
+// and finally the Go field name. Supported `msgpack` tag options are
+// "omitempty" and "-" (to skip a field); see buildStructInfo for the
+// struct-level "asarray" option.
+func Marshal(v any) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := encodeReflect(buf, reflect.ValueOf(v), encodeOptions{}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeOptions controls the encoding choices encodeReflect makes where the
+// spec allows more than one valid representation. The zero value is
+// Marshal's behavior; see CanonicalEncode for canonical.
+type encodeOptions struct {
+	canonical      bool
+	allowNonFinite bool
+}
+
+// encodeReflect encodes an arbitrary Go value, described by rv, to MessagePack.
+func encodeReflect(buf *bytes.Buffer, rv reflect.Value, opts encodeOptions) error {
+	if !rv.IsValid() {
+		buf.WriteByte(0xc0)
+		return nil
+	}
+
+	if m, ok := marshalerFor(rv); ok {
+		raw, err := m.MarshalMsgPack()
+		if err != nil {
+			return fmt.Errorf("msgpack: error calling MarshalMsgPack for type %s: %w", rv.Type(), err)
+		}
+		buf.Write(raw)
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		return encodeReflect(buf, rv.Elem(), opts)
+	case reflect.Bool:
+		if rv.Bool() {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if opts.canonical {
+			encodeCanonicalInt(buf, rv.Int())
+			return nil
+		}
+		return encodeSignedInt(buf, rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return encodeUnsignedInt(buf, rv.Uint())
+	case reflect.Float32:
+		if opts.canonical {
+			return encodeCanonicalFloat(buf, float64(rv.Float()), opts)
+		}
+		encodeFloat32(buf, float32(rv.Float()))
+	case reflect.Float64:
+		if opts.canonical {
+			return encodeCanonicalFloat(buf, rv.Float(), opts)
+		}
+		encodeFloat64(buf, rv.Float())
+	case reflect.String:
+		encodeString(buf, rv.String())
+	case reflect.Slice:
+		if rv.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			encodeBin(buf, rv.Bytes())
+			return nil
+		}
+		return encodeReflectArray(buf, rv, opts)
+	case reflect.Array:
+		if ok, err := tryEncodeExtension(buf, rv.Interface()); ok {
+			return err
+		}
+		return encodeReflectArray(buf, rv, opts)
+	case reflect.Map:
+		return encodeReflectMap(buf, rv, opts)
+	case reflect.Struct:
+		if rv.Type() == timeType {
+			encodeTimestamp(buf, rv.Interface().(time.Time))
+			return nil
+		}
+		if ok, err := tryEncodeExtension(buf, rv.Interface()); ok {
+			return err
+		}
+		return encodeReflectStruct(buf, rv, opts)
+	default:
+		if ok, err := tryEncodeExtension(buf, rv.Interface()); ok {
+			return err
+		}
+		return fmt.Errorf("msgpack: unsupported type: %s", rv.Type())
+	}
+	return nil
+}
+
+// marshalerFor reports whether rv (or its address) implements Marshaler.
+func marshalerFor(rv reflect.Value) (Marshaler, bool) {
+	if rv.Type().Implements(marshalerType) {
+		if rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil, false
+		}
+		return rv.Interface().(Marshaler), true
+	}
+	if rv.CanAddr() && reflect.PtrTo(rv.Type()).Implements(marshalerType) {
+		return rv.Addr().Interface().(Marshaler), true
+	}
+	return nil, false
+}
+
+func encodeReflectArray(buf *bytes.Buffer, rv reflect.Value, opts encodeOptions) error {
+	length := rv.Len()
+	writeArrayHeader(buf, length)
+	for i := 0; i < length; i++ {
+		if err := encodeReflect(buf, rv.Index(i), opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeReflectMap encodes a map. Only maps with string keys are supported,
+// matching the string-keyed maps the reflection-free decoder produces.
+func encodeReflectMap(buf *bytes.Buffer, rv reflect.Value, opts encodeOptions) error {
+	if rv.IsNil() {
+		buf.WriteByte(0xc0)
+		return nil
+	}
+	if rv.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("msgpack: unsupported map key type: %s", rv.Type().Key())
+	}
+	keys := rv.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	writeMapHeader(buf, len(keys))
+	for _, k := range keys {
+		encodeString(buf, k.String())
+		if err := encodeReflect(buf, rv.MapIndex(k), opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeReflectStruct(buf *bytes.Buffer, rv reflect.Value, opts encodeOptions) error {
+	info := cachedStructInfo(rv.Type())
+
+	if info.asArray {
+		writeArrayHeader(buf, len(info.fields))
+		for _, f := range info.fields {
+			if err := encodeReflect(buf, rv.FieldByIndex(f.index), opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	included := make([]fieldInfo, 0, len(info.fields))
+	for _, f := range info.fields {
+		if f.omitEmpty && isEmptyValue(rv.FieldByIndex(f.index)) {
+			continue
+		}
+		included = append(included, f)
+	}
+	writeMapHeader(buf, len(included))
+	for _, f := range included {
+		encodeString(buf, f.name)
+		if err := encodeReflect(buf, rv.FieldByIndex(f.index), opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
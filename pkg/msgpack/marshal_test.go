@@ -0,0 +1,127 @@
+package msgpack_test
+
+import (
+	"testing"
+
+	"github.com/HackHow/messagepack-project/pkg/msgpack"
+	"github.com/stretchr/testify/assert"
+)
+
+type address struct {
+	City string `msgpack:"city"`
+	Zip  string `msgpack:"zip,omitempty"`
+}
+
+type person struct {
+	Name    string   `msgpack:"name"`
+	Age     int      `msgpack:"age"`
+	Tags    []string `msgpack:"tags,omitempty"`
+	Address address  `msgpack:"address"`
+	Parent  *person  `msgpack:"parent,omitempty"`
+}
+
+type point struct {
+	_ struct{} `msgpack:",asarray"`
+	X int
+	Y int
+}
+
+// rawString round-trips through MarshalMsgPack/UnmarshalMsgPack by wrapping
+// a plain string, exercising the custom (un)marshaler hooks.
+type rawString struct {
+	Value string
+}
+
+func (r rawString) MarshalMsgPack() ([]byte, error) {
+	return msgpack.Marshal(r.Value)
+}
+
+func (r *rawString) UnmarshalMsgPack(data []byte) error {
+	return msgpack.Unmarshal(data, &r.Value)
+}
+
+func TestMarshalUnmarshalStruct(t *testing.T) {
+	in := person{
+		Name: "Ada",
+		Age:  36,
+		Tags: []string{"engineer", "mathematician"},
+		Address: address{
+			City: "London",
+		},
+		Parent: &person{Name: "Parent", Age: 60, Address: address{City: "Bristol"}},
+	}
+
+	data, err := msgpack.Marshal(in)
+	assert.NoError(t, err)
+
+	var out person
+	assert.NoError(t, msgpack.Unmarshal(data, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestMarshalOmitsEmptyFields(t *testing.T) {
+	in := person{Name: "Grace", Age: 30, Address: address{City: "NYC"}}
+
+	data, err := msgpack.Marshal(in)
+	assert.NoError(t, err)
+
+	var asMap map[string]interface{}
+	assert.NoError(t, msgpack.Unmarshal(data, &asMap))
+	_, hasTags := asMap["tags"]
+	_, hasParent := asMap["parent"]
+	assert.False(t, hasTags)
+	assert.False(t, hasParent)
+}
+
+func TestMarshalUnmarshalAsArray(t *testing.T) {
+	in := point{X: 3, Y: 4}
+
+	data, err := msgpack.Marshal(in)
+	assert.NoError(t, err)
+
+	var out point
+	assert.NoError(t, msgpack.Unmarshal(data, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestMarshalUnmarshalCustomMarshaler(t *testing.T) {
+	in := rawString{Value: "hello"}
+
+	data, err := msgpack.Marshal(in)
+	assert.NoError(t, err)
+
+	var out rawString
+	assert.NoError(t, msgpack.Unmarshal(data, &out))
+	assert.Equal(t, in.Value, out.Value)
+}
+
+func TestUnmarshalRequiresPointer(t *testing.T) {
+	var out person
+	err := msgpack.Unmarshal([]byte{0x80}, out)
+	assert.Error(t, err)
+}
+
+// TestUnmarshalRejectsStr32Header guards against a str32 (0xdb) header
+// silently decoding with a truncated 2-byte length (the str16 format's
+// length field) instead of erroring: str32 isn't supported by either decode
+// path, so it must fail loudly rather than corrupt the rest of the stream.
+func TestUnmarshalRejectsStr32Header(t *testing.T) {
+	data := []byte{0xdb, 0x00, 0x01, 0x12, 0x34, 'a'}
+	var out string
+	err := msgpack.Unmarshal(data, &out)
+	assert.Error(t, err)
+}
+
+// TestMarshalRoundTripsPositiveIntsAbove127 guards against encodeSignedInt
+// writing 128-255 as 0xd0 (int8, signed, -128..127), which silently
+// corrupts any value in that range: 200 round-tripped to -56.
+func TestMarshalRoundTripsPositiveIntsAbove127(t *testing.T) {
+	for _, n := range []int{128, 200, 255, 256, 1000} {
+		data, err := msgpack.Marshal(n)
+		assert.NoError(t, err)
+
+		var out int
+		assert.NoError(t, msgpack.Unmarshal(data, &out))
+		assert.Equal(t, n, out)
+	}
+}
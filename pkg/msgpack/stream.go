@@ -0,0 +1,73 @@
+package msgpack
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+)
+
+// Encoder writes a sequence of MessagePack values to an output stream,
+// analogous to json.Encoder. Each call to Encode writes exactly one value.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns a new Encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the MessagePack encoding of v to the stream, using the same
+// struct tag-driven rules as Marshal.
+func (e *Encoder) Encode(v any) error {
+	buf := &bytes.Buffer{}
+	if err := encodeReflect(buf, reflect.ValueOf(v), encodeOptions{}); err != nil {
+		return err
+	}
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// Decoder reads a sequence of MessagePack values from an input stream,
+// analogous to json.Decoder. Apart from the fixed-size read-ahead of its
+// bufio.Reader, it never buffers more than one value's header and payload
+// at a time: Decode peeks the one header byte for the next value, then
+// reads exactly the bytes that value's length declares, so
+// large arrays and maps stream element-by-element rather than loading the
+// whole payload into memory up front.
+type Decoder struct {
+	r *bufio.Reader
+}
+
+// NewDecoder returns a new Decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	if br, ok := r.(*bufio.Reader); ok {
+		return &Decoder{r: br}
+	}
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next MessagePack value from the stream and stores it in
+// v, which must be a non-nil pointer. It returns io.EOF once the stream is
+// exhausted.
+func (d *Decoder) Decode(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidDecodeTargetError{Type: reflect.TypeOf(v)}
+	}
+	return decodeReflect(d.r, rv.Elem(), newDecodeState(DecodeOptions{}))
+}
+
+// InvalidDecodeTargetError is returned by Decoder.Decode (and Unmarshal via
+// its own check) when passed a value that isn't a non-nil pointer.
+type InvalidDecodeTargetError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidDecodeTargetError) Error() string {
+	if e.Type == nil {
+		return "msgpack: Decode(nil)"
+	}
+	return "msgpack: Decode requires a non-nil pointer, got " + e.Type.String()
+}
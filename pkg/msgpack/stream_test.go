@@ -0,0 +1,50 @@
+package msgpack_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/HackHow/messagepack-project/pkg/msgpack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncoderDecoderRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+
+	values := []person{
+		{Name: "Ada", Age: 36, Address: address{City: "London"}},
+		{Name: "Grace", Age: 30, Address: address{City: "NYC"}},
+	}
+	for _, v := range values {
+		assert.NoError(t, enc.Encode(v))
+	}
+
+	dec := msgpack.NewDecoder(&buf)
+	var got []person
+	for {
+		var v person
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		got = append(got, v)
+	}
+	assert.Equal(t, values, got)
+}
+
+func TestDecoderReturnsEOFOnEmptyStream(t *testing.T) {
+	dec := msgpack.NewDecoder(bytes.NewReader(nil))
+	var v interface{}
+	err := dec.Decode(&v)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDecoderRequiresPointer(t *testing.T) {
+	dec := msgpack.NewDecoder(bytes.NewReader([]byte{0x01}))
+	var v int
+	err := dec.Decode(v)
+	assert.Error(t, err)
+}
@@ -0,0 +1,136 @@
+package msgpack
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// fieldInfo describes one exported struct field as seen by the struct
+// tag-driven codec.
+type fieldInfo struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// structInfo describes how a struct type should be encoded/decoded.
+type structInfo struct {
+	fields  []fieldInfo
+	asArray bool
+}
+
+var structInfoCache sync.Map // map[reflect.Type]structInfo
+
+// cachedStructInfo returns the (cached) structInfo for t, computing it on
+// first use. t must be a struct type.
+func cachedStructInfo(t reflect.Type) structInfo {
+	if cached, ok := structInfoCache.Load(t); ok {
+		return cached.(structInfo)
+	}
+	info := buildStructInfo(t)
+	structInfoCache.Store(t, info)
+	return info
+}
+
+// buildStructInfo walks t (and any embedded structs) collecting field tags.
+// Field resolution mirrors encoding/json: a field is looked up first by its
+// `msgpack` tag, falling back to `json` when no `msgpack` tag is present.
+// An unexported blank field (`_`) tagged with the "asarray" option switches
+// the whole struct to positional array encoding, e.g.:
+//
+//	type Point struct {
+//		_ struct{} `msgpack:",asarray"`
+//		X int
+//		Y int
+//	}
+func buildStructInfo(t reflect.Type) structInfo {
+	info := structInfo{}
+	var walk func(t reflect.Type, index []int)
+	walk = func(t reflect.Type, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			fieldIndex := append(append([]int{}, index...), i)
+
+			name, opts := parseTag(f)
+			if f.Name == "_" {
+				if hasOption(opts, "asarray") {
+					info.asArray = true
+				}
+				continue
+			}
+			if f.PkgPath != "" && !f.Anonymous {
+				continue // unexported field
+			}
+			if name == "-" {
+				continue
+			}
+
+			ft := f.Type
+			if f.Anonymous && name == "" {
+				embedded := ft
+				if embedded.Kind() == reflect.Ptr {
+					embedded = embedded.Elem()
+				}
+				if embedded.Kind() == reflect.Struct {
+					walk(embedded, fieldIndex)
+					continue
+				}
+			}
+
+			if name == "" {
+				name = f.Name
+			}
+			info.fields = append(info.fields, fieldInfo{
+				name:      name,
+				index:     fieldIndex,
+				omitEmpty: hasOption(opts, "omitempty"),
+			})
+		}
+	}
+	walk(t, nil)
+	return info
+}
+
+// parseTag extracts the name and options portion of a field's tag, checking
+// `msgpack` first and falling back to `json`.
+func parseTag(f reflect.StructField) (string, []string) {
+	tag, ok := f.Tag.Lookup("msgpack")
+	if !ok {
+		tag = f.Tag.Get("json")
+	}
+	if tag == "" {
+		return "", nil
+	}
+	parts := strings.Split(tag, ",")
+	return parts[0], parts[1:]
+}
+
+func hasOption(opts []string, name string) bool {
+	for _, o := range opts {
+		if strings.EqualFold(o, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmptyValue reports whether v is the zero value for its type, per the
+// same rules `encoding/json` uses for `omitempty`.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
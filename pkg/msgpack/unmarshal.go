@@ -0,0 +1,592 @@
+package msgpack
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Unmarshaler is implemented by types that decode themselves directly from
+// MessagePack bytes, analogous to encoding/json's Unmarshaler. data holds
+// exactly one complete MessagePack value.
+type Unmarshaler interface {
+	UnmarshalMsgPack(data []byte) error
+}
+
+var unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+
+// Unmarshal decodes MessagePack data into v, which must be a non-nil
+// pointer. Like Marshal, it uses `msgpack`/`json` struct tags and operates
+// directly on v's type via reflection instead of bridging through
+// encoding/json.
+func Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidDecodeTargetError{Type: reflect.TypeOf(v)}
+	}
+	buf := bufio.NewReader(bytes.NewReader(data))
+	return decodeReflect(buf, rv.Elem(), newDecodeState(DecodeOptions{}))
+}
+
+// decodeReflect decodes a single MessagePack value from buf into rv, which
+// must be addressable (settable). st tracks the same nesting-depth and
+// value-count limits decodeValue enforces, since the reflection path reads
+// attacker-controlled array/map/string lengths just as directly.
+func decodeReflect(buf *bufio.Reader, rv reflect.Value, st *decodeState) error {
+	if rv.Kind() == reflect.Ptr {
+		b, err := peekByte(buf)
+		if err != nil {
+			return err
+		}
+		if b == 0xc0 {
+			buf.ReadByte()
+			rv.Set(reflect.Zero(rv.Type()))
+			return nil
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return decodeReflect(buf, rv.Elem(), st)
+	}
+
+	if rv.CanAddr() && reflect.PtrTo(rv.Type()).Implements(unmarshalerType) {
+		raw, err := captureRawValue(buf, st)
+		if err != nil {
+			return err
+		}
+		return rv.Addr().Interface().(Unmarshaler).UnmarshalMsgPack(raw)
+	}
+
+	if rv.Kind() == reflect.Interface && rv.NumMethod() == 0 {
+		val, err := decodeValue(buf, st)
+		if err != nil {
+			return err
+		}
+		if val == nil {
+			rv.Set(reflect.Zero(rv.Type()))
+		} else {
+			rv.Set(reflect.ValueOf(val))
+		}
+		return nil
+	}
+
+	b, err := peekByte(buf)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case b <= 0x7f:
+		buf.ReadByte()
+		return setInt(rv, int64(b))
+	case b >= 0xe0:
+		buf.ReadByte()
+		return setInt(rv, int64(int8(b)))
+	case b >= 0xa0 && b <= 0xbf || b == 0xd9 || b == 0xda:
+		s, err := decodeReflectStringHeader(buf, b)
+		if err != nil {
+			return err
+		}
+		return setString(rv, s)
+	case b >= 0x90 && b <= 0x9f || b == 0xdc || b == 0xdd:
+		length, err := decodeReflectArrayHeader(buf, b)
+		if err != nil {
+			return err
+		}
+		if rv.Kind() == reflect.Struct {
+			return decodeReflectStruct(buf, rv, length, st)
+		}
+		return decodeReflectArray(buf, rv, length, st)
+	case b >= 0x80 && b <= 0x8f || b == 0xde || b == 0xdf:
+		length, err := decodeReflectMapHeader(buf, b)
+		if err != nil {
+			return err
+		}
+		return decodeReflectMapOrStruct(buf, rv, length, st)
+	}
+
+	switch b {
+	case 0xc0:
+		buf.ReadByte()
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	case 0xc2:
+		buf.ReadByte()
+		return setBool(rv, false)
+	case 0xc3:
+		buf.ReadByte()
+		return setBool(rv, true)
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := decodeReflectUint(buf, b)
+		if err != nil {
+			return err
+		}
+		return setUint(rv, n)
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n, err := decodeReflectInt(buf, b)
+		if err != nil {
+			return err
+		}
+		return setInt(rv, n)
+	case 0xca:
+		buf.ReadByte()
+		bs, err := safeReadN(buf, 4)
+		if err != nil {
+			return err
+		}
+		bits := uint32(bs[0])<<24 | uint32(bs[1])<<16 | uint32(bs[2])<<8 | uint32(bs[3])
+		return setFloat(rv, float64(math.Float32frombits(bits)))
+	case 0xcb:
+		buf.ReadByte()
+		bs, err := safeReadN(buf, 8)
+		if err != nil {
+			return err
+		}
+		return setFloat(rv, decodeFloat64(bs))
+	case 0xc4, 0xc5, 0xc6:
+		buf.ReadByte()
+		length, err := readBinHeaderLength(buf, b)
+		if err != nil {
+			return err
+		}
+		data, err := safeReadN(buf, length)
+		if err != nil {
+			return err
+		}
+		return setBytes(rv, data)
+	case 0xc7, 0xc8, 0xc9, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		buf.ReadByte()
+		typeCode, data, err := readExtHeader(buf, b)
+		if err != nil {
+			return err
+		}
+		return setExtension(rv, typeCode, data)
+	default:
+		return fmt.Errorf("msgpack: unsupported byte: 0x%x", b)
+	}
+}
+
+func peekByte(buf *bufio.Reader) (byte, error) {
+	b, err := buf.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// captureRawValue decodes the next MessagePack value, returning the exact
+// bytes it consumed. Used to hand a self-contained value to a custom
+// Unmarshaler.
+func captureRawValue(buf *bufio.Reader, st *decodeState) ([]byte, error) {
+	rec := &recordingReader{r: buf}
+	if _, err := decodeValue(rec, st); err != nil {
+		return nil, err
+	}
+	return rec.buf.Bytes(), nil
+}
+
+// recordingReader wraps a byteReader, copying every byte it yields into an
+// in-memory buffer so the exact bytes of one decoded value can be recovered
+// afterwards.
+type recordingReader struct {
+	r   byteReader
+	buf bytes.Buffer
+}
+
+func (rr *recordingReader) ReadByte() (byte, error) {
+	b, err := rr.r.ReadByte()
+	if err == nil {
+		rr.buf.WriteByte(b)
+	}
+	return b, err
+}
+
+func (rr *recordingReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	if n > 0 {
+		rr.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+func decodeReflectStringHeader(buf *bufio.Reader, b byte) (string, error) {
+	buf.ReadByte()
+	switch {
+	case b >= 0xa0 && b <= 0xbf:
+		return readString(buf, int(b&0x1f), InvalidUTF8Error)
+	case b == 0xd9:
+		l, err := buf.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		return readString(buf, int(l), InvalidUTF8Error)
+	default: // 0xda
+		bs, err := safeReadN(buf, 2)
+		if err != nil {
+			return "", err
+		}
+		return readString(buf, int(bs[0])<<8|int(bs[1]), InvalidUTF8Error)
+	}
+}
+
+func decodeReflectArrayHeader(buf *bufio.Reader, b byte) (int, error) {
+	buf.ReadByte()
+	switch {
+	case b >= 0x90 && b <= 0x9f:
+		return int(b & 0x0f), nil
+	case b == 0xdc:
+		bs, err := safeReadN(buf, 2)
+		if err != nil {
+			return 0, err
+		}
+		return int(bs[0])<<8 | int(bs[1]), nil
+	default: // 0xdd
+		bs, err := safeReadN(buf, 4)
+		if err != nil {
+			return 0, err
+		}
+		return int(bs[0])<<24 | int(bs[1])<<16 | int(bs[2])<<8 | int(bs[3]), nil
+	}
+}
+
+func decodeReflectMapHeader(buf *bufio.Reader, b byte) (int, error) {
+	buf.ReadByte()
+	switch {
+	case b >= 0x80 && b <= 0x8f:
+		return int(b & 0x0f), nil
+	case b == 0xde:
+		bs, err := safeReadN(buf, 2)
+		if err != nil {
+			return 0, err
+		}
+		return int(bs[0])<<8 | int(bs[1]), nil
+	default: // 0xdf
+		bs, err := safeReadN(buf, 4)
+		if err != nil {
+			return 0, err
+		}
+		return int(bs[0])<<24 | int(bs[1])<<16 | int(bs[2])<<8 | int(bs[3]), nil
+	}
+}
+
+func decodeReflectUint(buf *bufio.Reader, b byte) (uint64, error) {
+	buf.ReadByte()
+	switch b {
+	case 0xcc:
+		v, err := buf.ReadByte()
+		return uint64(v), err
+	case 0xcd:
+		bs, err := safeReadN(buf, 2)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(bs[0])<<8 | uint64(bs[1]), nil
+	case 0xce:
+		bs, err := safeReadN(buf, 4)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(bs[0])<<24 | uint64(bs[1])<<16 | uint64(bs[2])<<8 | uint64(bs[3]), nil
+	default: // 0xcf
+		bs, err := safeReadN(buf, 8)
+		if err != nil {
+			return 0, err
+		}
+		var v uint64
+		for i := 0; i < 8; i++ {
+			v = (v << 8) | uint64(bs[i])
+		}
+		return v, nil
+	}
+}
+
+func decodeReflectInt(buf *bufio.Reader, b byte) (int64, error) {
+	buf.ReadByte()
+	switch b {
+	case 0xd0:
+		v, err := buf.ReadByte()
+		return int64(int8(v)), err
+	case 0xd1:
+		bs, err := safeReadN(buf, 2)
+		if err != nil {
+			return 0, err
+		}
+		return int64(int16(int(bs[0])<<8 | int(bs[1]))), nil
+	case 0xd2:
+		bs, err := safeReadN(buf, 4)
+		if err != nil {
+			return 0, err
+		}
+		return int64(int32(int(bs[0])<<24 | int(bs[1])<<16 | int(bs[2])<<8 | int(bs[3]))), nil
+	default: // 0xd3
+		bs, err := safeReadN(buf, 8)
+		if err != nil {
+			return 0, err
+		}
+		var v int64
+		for i := 0; i < 8; i++ {
+			v = (v << 8) | int64(bs[i])
+		}
+		return v, nil
+	}
+}
+
+// decodeReflectArray decodes a MessagePack array into rv. length comes
+// straight off an attacker-controlled array16/array32 header, so it's only
+// ever used as a preallocation hint (via boundedCap): the slice itself grows
+// by appending as elements actually decode, the same bounded-prealloc
+// approach readArray uses.
+func decodeReflectArray(buf *bufio.Reader, rv reflect.Value, length int, st *decodeState) error {
+	if err := checkDeclaredLength(buf, length); err != nil {
+		return err
+	}
+	if err := st.enterContainer(); err != nil {
+		return err
+	}
+	defer st.exitContainer()
+
+	switch rv.Kind() {
+	case reflect.Slice:
+		rv.Set(reflect.MakeSlice(rv.Type(), 0, boundedCap(length)))
+		for i := 0; i < length; i++ {
+			if err := st.countValue(); err != nil {
+				return err
+			}
+			elem := reflect.New(rv.Type().Elem()).Elem()
+			if err := decodeReflect(buf, elem, st); err != nil {
+				return err
+			}
+			rv.Set(reflect.Append(rv, elem))
+		}
+		return nil
+	case reflect.Array:
+		for i := 0; i < length; i++ {
+			if err := st.countValue(); err != nil {
+				return err
+			}
+			if i < rv.Len() {
+				if err := decodeReflect(buf, rv.Index(i), st); err != nil {
+					return err
+				}
+			} else if _, err := decodeValue(buf, st); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("msgpack: cannot decode array into %s", rv.Type())
+	}
+}
+
+func decodeReflectMapOrStruct(buf *bufio.Reader, rv reflect.Value, length int, st *decodeState) error {
+	switch rv.Kind() {
+	case reflect.Struct:
+		return decodeReflectStruct(buf, rv, length, st)
+	case reflect.Map:
+		return decodeReflectMap(buf, rv, length, st)
+	default:
+		return fmt.Errorf("msgpack: cannot decode map into %s", rv.Type())
+	}
+}
+
+// decodeReflectMap decodes a MessagePack map into rv. Only maps with string
+// keys are supported, matching the string-keyed maps the encoder produces.
+// As with decodeReflectArray, length is only ever used as a bounded
+// preallocation hint, never trusted outright.
+func decodeReflectMap(buf *bufio.Reader, rv reflect.Value, length int, st *decodeState) error {
+	if rv.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("msgpack: unsupported map key type: %s", rv.Type().Key())
+	}
+	if err := checkDeclaredLength(buf, length); err != nil {
+		return err
+	}
+	if err := st.enterContainer(); err != nil {
+		return err
+	}
+	defer st.exitContainer()
+
+	m := reflect.MakeMapWithSize(rv.Type(), boundedCap(length))
+	elemType := rv.Type().Elem()
+	for i := 0; i < length; i++ {
+		if err := st.countValue(); err != nil {
+			return err
+		}
+		key, err := decodeValue(buf, st)
+		if err != nil {
+			return err
+		}
+		keyStr, ok := key.(string)
+		if !ok {
+			return errors.New("msgpack: non-string map key")
+		}
+		elem := reflect.New(elemType).Elem()
+		if err := decodeReflect(buf, elem, st); err != nil {
+			return err
+		}
+		m.SetMapIndex(reflect.ValueOf(keyStr).Convert(rv.Type().Key()), elem)
+	}
+	rv.Set(m)
+	return nil
+}
+
+// decodeReflectStruct decodes a MessagePack map or array (see the struct's
+// "asarray" option) of length fields into rv's fields, by tag name or
+// position respectively. Unknown map keys and surplus array elements are
+// skipped. length is an attacker-controlled header value, so every field
+// (known or skipped) is still paced through st.countValue.
+func decodeReflectStruct(buf *bufio.Reader, rv reflect.Value, length int, st *decodeState) error {
+	if err := checkDeclaredLength(buf, length); err != nil {
+		return err
+	}
+	if err := st.enterContainer(); err != nil {
+		return err
+	}
+	defer st.exitContainer()
+
+	info := cachedStructInfo(rv.Type())
+
+	if info.asArray {
+		for i := 0; i < length; i++ {
+			if err := st.countValue(); err != nil {
+				return err
+			}
+			if i < len(info.fields) {
+				if err := decodeReflect(buf, rv.FieldByIndex(info.fields[i].index), st); err != nil {
+					return err
+				}
+			} else if _, err := decodeValue(buf, st); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	byName := make(map[string]fieldInfo, len(info.fields))
+	for _, f := range info.fields {
+		byName[f.name] = f
+	}
+	for i := 0; i < length; i++ {
+		if err := st.countValue(); err != nil {
+			return err
+		}
+		key, err := decodeValue(buf, st)
+		if err != nil {
+			return err
+		}
+		keyStr, ok := key.(string)
+		if !ok {
+			return errors.New("msgpack: non-string map key")
+		}
+		f, known := byName[keyStr]
+		if !known {
+			if _, err := decodeValue(buf, st); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := decodeReflect(buf, rv.FieldByIndex(f.index), st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setInt(rv reflect.Value, n int64) error {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		rv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		rv.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		rv.SetFloat(float64(n))
+	default:
+		return fmt.Errorf("msgpack: cannot decode integer into %s", rv.Type())
+	}
+	return nil
+}
+
+func setUint(rv reflect.Value, n uint64) error {
+	switch rv.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		rv.SetUint(n)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		rv.SetInt(int64(n))
+	case reflect.Float32, reflect.Float64:
+		rv.SetFloat(float64(n))
+	default:
+		return fmt.Errorf("msgpack: cannot decode unsigned integer into %s", rv.Type())
+	}
+	return nil
+}
+
+func setFloat(rv reflect.Value, f float64) error {
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		rv.SetFloat(f)
+	default:
+		return fmt.Errorf("msgpack: cannot decode float into %s", rv.Type())
+	}
+	return nil
+}
+
+func setBool(rv reflect.Value, b bool) error {
+	if rv.Kind() != reflect.Bool {
+		return fmt.Errorf("msgpack: cannot decode bool into %s", rv.Type())
+	}
+	rv.SetBool(b)
+	return nil
+}
+
+func setString(rv reflect.Value, s string) error {
+	if rv.Kind() != reflect.String {
+		return fmt.Errorf("msgpack: cannot decode string into %s", rv.Type())
+	}
+	rv.SetString(s)
+	return nil
+}
+
+func setBytes(rv reflect.Value, data []byte) error {
+	if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() != reflect.Uint8 {
+		return fmt.Errorf("msgpack: cannot decode bin into %s", rv.Type())
+	}
+	rv.SetBytes(data)
+	return nil
+}
+
+// setExtension assigns a decoded ext value (timestamp, a registered
+// extension's decoded value, or a raw Extension as a last resort) into rv.
+func setExtension(rv reflect.Value, typeCode int8, data []byte) error {
+	if typeCode == timestampExtensionType {
+		t, err := decodeTimestamp(data)
+		if err != nil {
+			return err
+		}
+		if rv.Type() != timeType {
+			return fmt.Errorf("msgpack: cannot decode timestamp into %s", rv.Type())
+		}
+		rv.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	if dec, ok := lookupExtensionDecoder(typeCode); ok {
+		val, err := dec(data)
+		if err != nil {
+			return err
+		}
+		vv := reflect.ValueOf(val)
+		if !vv.Type().AssignableTo(rv.Type()) {
+			return fmt.Errorf("msgpack: decoded extension type %s is not assignable to %s", vv.Type(), rv.Type())
+		}
+		rv.Set(vv)
+		return nil
+	}
+
+	if rv.Type() != reflect.TypeOf(Extension{}) {
+		return fmt.Errorf("msgpack: no decoder registered for extension type %d", typeCode)
+	}
+	rv.Set(reflect.ValueOf(Extension{Type: typeCode, Data: append([]byte(nil), data...)}))
+	return nil
+}